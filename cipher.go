@@ -0,0 +1,150 @@
+package wal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+)
+
+// cipherChunkSize is the amount of plaintext sealed into a single AES-GCM
+// chunk. Segments are encrypted as a sequence of these chunks, each
+// independently sealed, rather than as one big AEAD call, so that a Reader
+// can start decrypting without buffering the whole (potentially huge)
+// segment in memory.
+const cipherChunkSize = 64 * 1024
+
+// Cipher optionally encrypts sealed (already-compressed) segments at rest
+// using AES-GCM. Each chunk's nonce is derived from the segment's file
+// sequence and a monotonically increasing chunk counter, so no nonce ever
+// needs to be stored alongside the ciphertext.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher constructs a Cipher from a raw AES key. The key must be 16, 24,
+// or 32 bytes long, selecting AES-128, AES-192, or AES-256 respectively.
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialize AES cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialize AES-GCM: %v", err)
+	}
+	if aead.NonceSize() != 12 {
+		return nil, fmt.Errorf("Unexpected AES-GCM nonce size %d", aead.NonceSize())
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) nonce(fileSequence int64, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	encoding.PutUint64(nonce[:8], uint64(fileSequence))
+	encoding.PutUint32(nonce[8:], uint32(counter))
+	return nonce
+}
+
+// encryptWriter wraps w, encrypting everything written to the returned
+// WriteCloser in cipherChunkSize chunks keyed to fileSequence. Close must be
+// called to flush and seal the final, possibly short, chunk.
+func (c *Cipher) encryptWriter(w io.Writer, fileSequence int64) io.WriteCloser {
+	return &cipherWriter{cipher: c, w: w, fileSequence: fileSequence, buf: make([]byte, 0, cipherChunkSize)}
+}
+
+// decryptReader wraps r, decrypting the chunked stream written by
+// encryptWriter for the same fileSequence.
+func (c *Cipher) decryptReader(r io.Reader, fileSequence int64) io.Reader {
+	return &cipherReader{cipher: c, r: r, fileSequence: fileSequence}
+}
+
+type cipherWriter struct {
+	cipher       *Cipher
+	w            io.Writer
+	fileSequence int64
+	counter      uint64
+	buf          []byte
+}
+
+func (cw *cipherWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := cap(cw.buf) - len(cw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		cw.buf = append(cw.buf, p[:n]...)
+		p = p[n:]
+		if len(cw.buf) == cap(cw.buf) {
+			if err := cw.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (cw *cipherWriter) flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	nonce := cw.cipher.nonce(cw.fileSequence, cw.counter)
+	cw.counter++
+	sealed := cw.cipher.aead.Seal(nil, nonce, cw.buf, nil)
+
+	lenBuf := make([]byte, lengthSize)
+	encoding.PutUint32(lenBuf, uint32(len(sealed)))
+	if _, err := cw.w.Write(lenBuf); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(sealed); err != nil {
+		return err
+	}
+	cw.buf = cw.buf[:0]
+	return nil
+}
+
+func (cw *cipherWriter) Close() error {
+	return cw.flush()
+}
+
+type cipherReader struct {
+	cipher       *Cipher
+	r            io.Reader
+	fileSequence int64
+	counter      uint64
+	buf          []byte
+}
+
+func (cr *cipherReader) Read(p []byte) (int, error) {
+	if len(cr.buf) == 0 {
+		if err := cr.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+func (cr *cipherReader) fill() error {
+	lenBuf := make([]byte, lengthSize)
+	if _, err := io.ReadFull(cr.r, lenBuf); err != nil {
+		return err
+	}
+
+	sealed := make([]byte, encoding.Uint32(lenBuf))
+	if _, err := io.ReadFull(cr.r, sealed); err != nil {
+		return err
+	}
+
+	nonce := cr.cipher.nonce(cr.fileSequence, cr.counter)
+	cr.counter++
+	plain, err := cr.cipher.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to decrypt chunk %d of segment %d: %v", cr.counter-1, cr.fileSequence, err)
+	}
+	cr.buf = plain
+	return nil
+}
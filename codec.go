@@ -0,0 +1,128 @@
+package wal
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encryptedSuffix is appended after a Codec's own suffix when a segment was
+// also encrypted with a Cipher, e.g. "1234.zst.enc".
+const encryptedSuffix = ".enc"
+
+// Codec compresses and decompresses segment data. Register additional
+// codecs with RegisterCodec; a codec's Suffix() is appended to a segment's
+// filename when it's sealed, so Reader.open can dispatch to the right codec
+// even when a directory contains segments sealed with different codecs
+// (for example after changing which codec CompressBefore uses partway
+// through the life of a WAL).
+type Codec interface {
+	// NewWriter wraps w, compressing everything written to the returned
+	// WriteCloser. Closing it flushes and finalizes the compressed stream;
+	// it does not close w.
+	NewWriter(w io.Writer) io.WriteCloser
+
+	// NewReader wraps r, decompressing everything read from it.
+	NewReader(r io.Reader) io.Reader
+
+	// Suffix is the filename suffix used for segments sealed with this
+	// codec, e.g. ".snappy".
+	Suffix() string
+}
+
+var codecRegistry = make(map[string]Codec)
+
+// RegisterCodec makes a Codec available for CompressBefore and Reader.open
+// to use, keyed by its Suffix(). Registering a codec under a suffix that's
+// already registered replaces it.
+func RegisterCodec(c Codec) {
+	codecRegistry[c.Suffix()] = c
+}
+
+func codecForSuffix(suffix string) Codec {
+	return codecRegistry[suffix]
+}
+
+// registeredCodecs returns the known codecs in no particular order, for use
+// when probing a directory for a segment sealed with one of them.
+func registeredCodecs() []Codec {
+	codecs := make([]Codec, 0, len(codecRegistry))
+	for _, c := range codecRegistry {
+		codecs = append(codecs, c)
+	}
+	return codecs
+}
+
+// isSealed reports whether name looks like a segment that's already been
+// run through a registered Codec (optionally also encrypted), so that
+// CompressBefore and appendSentinels can skip it.
+func isSealed(name string) bool {
+	for suffix := range codecRegistry {
+		if strings.HasSuffix(name, suffix) || strings.HasSuffix(name, suffix+encryptedSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterCodec(snappyCodec{})
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) NewWriter(w io.Writer) io.WriteCloser { return snappy.NewWriter(w) }
+func (snappyCodec) NewReader(r io.Reader) io.Reader      { return snappy.NewReader(r) }
+func (snappyCodec) Suffix() string                       { return compressedSuffix }
+
+type gzipCodec struct{}
+
+func (gzipCodec) NewWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func (gzipCodec) NewReader(r io.Reader) io.Reader {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return errorReader{err}
+	}
+	return gzr
+}
+
+func (gzipCodec) Suffix() string { return ".gz" }
+
+type zstdCodec struct{}
+
+func (zstdCodec) NewWriter(w io.Writer) io.WriteCloser {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		// zstd.NewWriter only fails given invalid options, and the built-in
+		// codec never supplies any.
+		panic(err)
+	}
+	return zw
+}
+
+func (zstdCodec) NewReader(r io.Reader) io.Reader {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return errorReader{err}
+	}
+	return zr
+}
+
+func (zstdCodec) Suffix() string { return ".zst" }
+
+// errorReader is an io.Reader that always fails with a fixed error, used to
+// surface a Codec construction error through the io.Reader interface
+// (NewReader has no error return of its own).
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
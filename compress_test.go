@@ -0,0 +1,55 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCompressBeforeLeavesSnapshotSidecarsAlone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	if _, err := w.Write([]byte("before snapshot")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if _, err := w.Snapshot([]byte("checkpoint")); err != nil {
+		t.Fatalf("Unable to snapshot: %v", err)
+	}
+	if _, err := w.Write([]byte("after snapshot")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unable to close WAL: %v", err)
+	}
+
+	w, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	// Compress everything up through the current tail; the .snap sidecar
+	// written by Snapshot must survive untouched.
+	if err := w.CompressBefore(newOffset(w.fileSequence, 0)); err != nil {
+		t.Fatalf("CompressBefore failed: %v", err)
+	}
+
+	offset, meta, err := w.LatestSnapshot()
+	if err != nil {
+		t.Fatalf("LatestSnapshot should still succeed after CompressBefore: %v", err)
+	}
+	if string(meta) != "checkpoint" {
+		t.Fatalf("Expected snapshot meta %q, got %q", "checkpoint", meta)
+	}
+	if offset == nil {
+		t.Fatalf("Expected a non-nil snapshot offset")
+	}
+}
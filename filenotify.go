@@ -0,0 +1,134 @@
+package wal
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileWatcher is the minimal interface Reader needs in order to wait for
+// filesystem changes instead of polling on a timer. It's implemented both
+// by fsnotifyWatcher, a thin wrapper around fsnotify, and by pollingWatcher,
+// the fallback used on
+// filesystems that don't support inotify/kqueue (many network mounts, for
+// example) — the same split Docker's pkg/filenotify uses for tailing
+// container logs.
+type FileWatcher interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Add(name string) error
+	Remove(name string) error
+	Close() error
+}
+
+// newFileWatcher returns an inotify/kqueue-backed FileWatcher, falling back
+// to a pollingWatcher if one can't be created on this platform.
+func newFileWatcher() FileWatcher {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Debugf("Unable to create fsnotify watcher, falling back to polling: %v", err)
+		return newPollingWatcher()
+	}
+	return &fsnotifyWatcher{w}
+}
+
+type fsnotifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func (f *fsnotifyWatcher) Events() <-chan fsnotify.Event { return f.w.Events }
+func (f *fsnotifyWatcher) Errors() <-chan error          { return f.w.Errors }
+func (f *fsnotifyWatcher) Add(name string) error         { return f.w.Add(name) }
+func (f *fsnotifyWatcher) Remove(name string) error      { return f.w.Remove(name) }
+func (f *fsnotifyWatcher) Close() error                  { return f.w.Close() }
+
+// pollingInterval is how often a pollingWatcher re-stats its watched paths.
+// It matches the Reader's historical fixed sleep, so filesystems without
+// inotify/kqueue support see no regression in tailing latency.
+const pollingInterval = 50 * time.Millisecond
+
+// pollingWatcher implements FileWatcher by periodically stat'ing its
+// watched paths and synthesizing an fsnotify.Write event whenever a path's
+// size or modification time changes.
+type pollingWatcher struct {
+	events chan fsnotify.Event
+	errors chan error
+	add    chan string
+	remove chan string
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPollingWatcher() *pollingWatcher {
+	w := &pollingWatcher{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		add:    make(chan string),
+		remove: make(chan string),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *pollingWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *pollingWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *pollingWatcher) Add(name string) error {
+	select {
+	case w.add <- name:
+		return nil
+	case <-w.done:
+		return os.ErrClosed
+	}
+}
+
+func (w *pollingWatcher) Remove(name string) error {
+	select {
+	case w.remove <- name:
+		return nil
+	case <-w.done:
+		return os.ErrClosed
+	}
+}
+
+func (w *pollingWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+func (w *pollingWatcher) run() {
+	watched := make(map[string]os.FileInfo)
+	ticker := time.NewTicker(pollingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case name := <-w.add:
+			info, _ := os.Stat(name)
+			watched[name] = info
+		case name := <-w.remove:
+			delete(watched, name)
+		case <-ticker.C:
+			for name, last := range watched {
+				info, err := os.Stat(name)
+				if err != nil {
+					continue
+				}
+				if last == nil || info.Size() != last.Size() || info.ModTime() != last.ModTime() {
+					watched[name] = info
+					event := fsnotify.Event{Name: name, Op: fsnotify.Write}
+					select {
+					case w.events <- event:
+					case <-w.done:
+						return
+					}
+				}
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
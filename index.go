@@ -0,0 +1,164 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// indexSuffix names a segment's sidecar index file, e.g. "1234.idx" for
+	// segment "1234" (or its compressed/encrypted variants).
+	indexSuffix = ".idx"
+
+	// indexEntrySize is the on-disk size of one indexEntry: three
+	// big-endian int64s (logical position, physical offset, wall time).
+	indexEntrySize = 24
+
+	defaultIndexEveryRecords = 1000
+	defaultIndexEveryBytes   = int64(1 << 20) // 1MB
+)
+
+// indexEntry records, for some point within a segment, the logical position
+// (the same units as Offset.Position()), the physical byte offset of that
+// point within the segment file on disk, and a wall clock time. Entries
+// recorded by Write mark the start of a record and carry the time it was
+// written; entries recorded by copyWithIndex while sealing a segment mark
+// wherever a flush landed, not necessarily a record boundary, and carry the
+// time of sealing. Reader.open uses physicalOffset to
+// seek close to a target logical position without decoding from the start
+// of the segment; WAL.OffsetForTime uses wallTimeNanos to find the record
+// nearest a given timestamp.
+type indexEntry struct {
+	logicalPosition int64
+	physicalOffset  int64
+	wallTimeNanos   int64
+}
+
+// writeIndexFile writes entries to path, replacing any existing file. An
+// empty entries slice is a no-op so that segments with nothing worth
+// indexing (too small to cross a single threshold) don't leave empty
+// sidecars lying around.
+func writeIndexFile(path string, entries []indexEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to create index file %v: %v", path, err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, indexEntrySize*len(entries))
+	for i, e := range entries {
+		off := i * indexEntrySize
+		binary.BigEndian.PutUint64(buf[off:], uint64(e.logicalPosition))
+		binary.BigEndian.PutUint64(buf[off+8:], uint64(e.physicalOffset))
+		binary.BigEndian.PutUint64(buf[off+16:], uint64(e.wallTimeNanos))
+	}
+
+	if _, err := file.Write(buf); err != nil {
+		return fmt.Errorf("Unable to write index file %v: %v", path, err)
+	}
+	return file.Sync()
+}
+
+// readIndexFile reads back entries written by writeIndexFile. A missing
+// file is not an error; it just yields no entries, since not every segment
+// is guaranteed to have been indexed (the active segment hasn't been
+// finalized yet, or indexing was enabled after it was written).
+func readIndexFile(path string) ([]indexEntry, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Unable to read index file %v: %v", path, err)
+	}
+
+	if len(raw)%indexEntrySize != 0 {
+		return nil, fmt.Errorf("Index file %v has unexpected length %d", path, len(raw))
+	}
+
+	entries := make([]indexEntry, len(raw)/indexEntrySize)
+	for i := range entries {
+		off := i * indexEntrySize
+		entries[i] = indexEntry{
+			logicalPosition: int64(binary.BigEndian.Uint64(raw[off:])),
+			physicalOffset:  int64(binary.BigEndian.Uint64(raw[off+8:])),
+			wallTimeNanos:   int64(binary.BigEndian.Uint64(raw[off+16:])),
+		}
+	}
+	return entries, nil
+}
+
+// countingWriter tracks how many bytes have been written through it, so
+// that CompressBefore can record physical file offsets for the index
+// sidecar after each of the codec's Flush calls.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// flusher is implemented by the stdlib and snappy/zstd compressors'
+// Writers, letting copyWithIndex force a byte-aligned checkpoint to record
+// in the index without waiting for Close.
+type flusher interface {
+	Flush() error
+}
+
+// copyWithIndex copies src into dst (a Codec's Writer, which may itself be
+// wrapped in a Cipher), flushing and recording an indexEntry every
+// everyBytes bytes of logical (uncompressed) data, using counted to learn
+// the physical offset each flush landed on. Each entry is stamped with the
+// time of the copy, not the time its data was written. If dst doesn't
+// support Flush, no entries are recorded and the copy proceeds as a plain
+// io.Copy.
+func copyWithIndex(dst io.Writer, src io.Reader, counted *countingWriter, everyBytes int64) ([]indexEntry, error) {
+	flushable, _ := dst.(flusher)
+	if flushable == nil || everyBytes <= 0 {
+		_, err := io.Copy(dst, src)
+		return nil, err
+	}
+
+	var entries []indexEntry
+	var logicalRead, sinceFlush int64
+	buf := make([]byte, defaultFileBuffer)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return entries, err
+			}
+			logicalRead += int64(n)
+			sinceFlush += int64(n)
+			if sinceFlush >= everyBytes {
+				if err := flushable.Flush(); err != nil {
+					return entries, err
+				}
+				entries = append(entries, indexEntry{
+					logicalPosition: logicalRead,
+					physicalOffset:  counted.n,
+					wallTimeNanos:   time.Now().UnixNano(),
+				})
+				sinceFlush = 0
+			}
+		}
+		if readErr == io.EOF {
+			return entries, nil
+		}
+		if readErr != nil {
+			return entries, readErr
+		}
+	}
+}
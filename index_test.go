@@ -0,0 +1,56 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestReaderAdvancePastIndexSidecar writes enough records to force several
+// segment cuts with an .idx sidecar on every one, then confirms a Reader
+// started from the beginning reads every record back in order instead of
+// getting stuck trying to open a bogus filename derived from the sidecar.
+func TestReaderAdvancePastIndexSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Shrink maxSegmentSize to the size of one frame so every record forces
+	// a segment cut (and thus an .idx sidecar, since IndexEveryRecords is
+	// 1), rather than writing megabytes of filler to trip the default
+	// threshold.
+	oldMaxSegmentSize := maxSegmentSize
+	maxSegmentSize = 11
+	defer func() { maxSegmentSize = oldMaxSegmentSize }()
+
+	w, err := OpenWithOptions(dir, &Options{IndexEveryRecords: 1})
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	records := []string{"one", "two", "three"}
+	for _, rec := range records {
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Fatalf("Unable to write %q: %v", rec, err)
+		}
+	}
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	for _, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unable to read %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
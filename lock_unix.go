@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package wal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileLock is an OS-level advisory lock on a single file, held for as long
+// as the underlying file descriptor stays open.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes an exclusive, non-blocking flock on path, creating it if
+// necessary. It returns ErrLocked if another process already holds it.
+func lockFile(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open lock file %v: %v", path, err)
+	}
+
+	if flockErr := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); flockErr != nil {
+		file.Close()
+		if flockErr == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("Unable to lock %v: %v", path, flockErr)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) unlock() error {
+	if err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN); err != nil {
+		l.file.Close()
+		return fmt.Errorf("Unable to unlock %v: %v", l.file.Name(), err)
+	}
+	return l.file.Close()
+}
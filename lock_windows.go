@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package wal
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileLock is an OS-level advisory lock on a single file, held for as long
+// as the underlying file handle stays open.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes an exclusive, non-blocking LockFileEx on path, creating it
+// if necessary. It returns ErrLocked if another process already holds it.
+func lockFile(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open lock file %v: %v", path, err)
+	}
+
+	overlapped := new(windows.Overlapped)
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, overlapped); err != nil {
+		file.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("Unable to lock %v: %v", path, err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) unlock() error {
+	overlapped := new(windows.Overlapped)
+	if err := windows.UnlockFileEx(windows.Handle(l.file.Fd()), 0, 1, 0, overlapped); err != nil {
+		l.file.Close()
+		return fmt.Errorf("Unable to unlock %v: %v", l.file.Name(), err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,36 @@
+package wal
+
+import "encoding/binary"
+
+// offset is the on-disk encoding of an Offset: a 16-byte big-endian pair of
+// (file sequence, position within that segment).
+type offset []byte
+
+// Offset identifies a position within a WAL: which segment (by its file
+// sequence) and how far into that segment's logical (uncompressed) stream.
+type Offset = offset
+
+func newOffset(fileSequence int64, position int64) Offset {
+	o := make(offset, 16)
+	binary.BigEndian.PutUint64(o, uint64(fileSequence))
+	binary.BigEndian.PutUint64(o[8:], uint64(position))
+	return o
+}
+
+// FileSequence returns the sequence number of the segment this Offset falls
+// within.
+func (o offset) FileSequence() int64 {
+	if len(o) == 0 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(o))
+}
+
+// Position returns the logical byte position within the segment identified
+// by FileSequence.
+func (o offset) Position() int64 {
+	if len(o) == 0 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(o[8:]))
+}
@@ -0,0 +1,92 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestOffsetForTime writes across multiple segments with distinguishable
+// timestamps and confirms OffsetForTime lands on the segment containing the
+// record nearest to, but not after, the target time - including the two
+// edge cases OffsetForTime handles specially: a time before everything on
+// disk, and a time past the still-active segment.
+func TestOffsetForTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Taken before Open creates the first segment, so it predates every
+	// segment on disk.
+	before := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("first segment")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	firstSegment := w.fileSequence
+
+	// fileSequence is derived from time.Now() at microsecond resolution, so
+	// sleeping between segments guarantees each one gets a distinguishable
+	// sequence to search on.
+	time.Sleep(5 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	if err := w.advance(); err != nil {
+		t.Fatalf("Unable to advance: %v", err)
+	}
+	if _, err := w.Write([]byte("second segment")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	secondSegment := w.fileSequence
+
+	time.Sleep(5 * time.Millisecond)
+	after := time.Now()
+
+	offset, err := w.OffsetForTime(before)
+	if err != nil {
+		t.Fatalf("OffsetForTime(before) failed: %v", err)
+	}
+	if offset.FileSequence() != firstSegment {
+		t.Fatalf("Expected a time predating every segment to land on %d, got %d", firstSegment, offset.FileSequence())
+	}
+
+	offset, err = w.OffsetForTime(between)
+	if err != nil {
+		t.Fatalf("OffsetForTime(between) failed: %v", err)
+	}
+	if offset.FileSequence() != firstSegment {
+		t.Fatalf("Expected a time between the two segments to land on %d, got %d", firstSegment, offset.FileSequence())
+	}
+
+	offset, err = w.OffsetForTime(after)
+	if err != nil {
+		t.Fatalf("OffsetForTime(after) failed: %v", err)
+	}
+	if offset.FileSequence() != secondSegment {
+		t.Fatalf("Expected a time past the active segment to land on %d, got %d", secondSegment, offset.FileSequence())
+	}
+
+	r, err := w.NewReader(offset)
+	if err != nil {
+		t.Fatalf("Unable to create reader at OffsetForTime(after): %v", err)
+	}
+	defer r.Close()
+	got, err := r.Read()
+	if err != nil {
+		t.Fatalf("Unable to read from OffsetForTime(after): %v", err)
+	}
+	if string(got) != "second segment" {
+		t.Fatalf("Expected %q, got %q", "second segment", got)
+	}
+}
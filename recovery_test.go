@@ -0,0 +1,212 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// flipCRCOfFirstFrame corrupts the CRC trailer of the first frame in an
+// uncompressed segment file, leaving its length prefix and payload intact,
+// to exercise Reader's scan-forward recovery.
+func flipCRCOfFirstFrame(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	length := int(encoding.Uint32(raw))
+	crcOffset := lengthSize + length
+	raw[crcOffset] ^= 0xFF
+	return ioutil.WriteFile(path, raw, 0600)
+}
+
+// TestReadRecoversFromCorruptFrame confirms that a frame whose CRC32 fails
+// to validate is skipped via recover, rather than returned as-is or left to
+// wedge the Reader, and that the next valid frame is still returned.
+func TestReadRecoversFromCorruptFrame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	if _, err := w.Write([]byte("corrupted")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if _, err := w.Write([]byte("still good")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	segment := w.filename()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unable to close: %v", err)
+	}
+
+	if err := flipCRCOfFirstFrame(segment); err != nil {
+		t.Fatalf("Unable to corrupt segment: %v", err)
+	}
+
+	w, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	got, err := r.ReadContext(ctx)
+	if err != nil {
+		t.Fatalf("Unable to read past corrupt frame: %v", err)
+	}
+	if string(got) != "still good" {
+		t.Fatalf("Expected recovery to land on %q, got %q", "still good", got)
+	}
+}
+
+// TestRepairSegmentTruncatesIncompleteFrame confirms RepairSegment discards
+// a trailing partial frame (as a crash mid-write would leave behind) while
+// preserving every complete, checksum-verified frame before it.
+func TestRepairSegmentTruncatesIncompleteFrame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	if _, err := w.Write([]byte("complete record")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	segment := w.filename()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unable to close: %v", err)
+	}
+
+	// Simulate a crash mid-write: a length prefix promising more payload
+	// than was ever actually written.
+	file, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Unable to open segment to append a partial frame: %v", err)
+	}
+	lenBuf := make([]byte, lengthSize)
+	encoding.PutUint32(lenBuf, 100)
+	if _, err := file.Write(lenBuf); err != nil {
+		t.Fatalf("Unable to append partial frame length: %v", err)
+	}
+	if _, err := file.Write([]byte("truncated")); err != nil {
+		t.Fatalf("Unable to append partial frame payload: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Unable to close segment after appending partial frame: %v", err)
+	}
+
+	before, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Unable to stat segment: %v", err)
+	}
+
+	if err := RepairSegment(segment); err != nil {
+		t.Fatalf("RepairSegment failed: %v", err)
+	}
+
+	after, err := os.Stat(segment)
+	if err != nil {
+		t.Fatalf("Unable to stat repaired segment: %v", err)
+	}
+	if after.Size() >= before.Size() {
+		t.Fatalf("Expected RepairSegment to truncate the partial trailing frame, size was %d, still %d", before.Size(), after.Size())
+	}
+
+	w, err = OpenWithOptions(dir, &Options{})
+	if err != nil {
+		t.Fatalf("Unable to reopen repaired WAL: %v", err)
+	}
+	defer w.Close()
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	got, err := r.ReadContext(ctx)
+	if err != nil {
+		t.Fatalf("Unable to read complete record after repair: %v", err)
+	}
+	if string(got) != "complete record" {
+		t.Fatalf("Expected %q, got %q", "complete record", got)
+	}
+}
+
+// TestReadSurvivesRecordThatPushesSegmentPastMaxSize confirms that the final
+// record of a segment - which is always the one whose write is what pushes
+// position past maxSegmentSize, since Write only checks position against
+// maxSegmentSize after appending - is read back normally instead of being
+// misdiagnosed as corrupt. A bound computed as maxSegmentSize - r.position
+// goes negative for exactly this record on every segment, which used to
+// send it into recover() and then hang forever waiting for a segment that
+// will never arrive.
+func TestReadSurvivesRecordThatPushesSegmentPastMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldMaxSegmentSize := maxSegmentSize
+	maxSegmentSize = 20
+	defer func() { maxSegmentSize = oldMaxSegmentSize }()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	// Each 10-byte record frames to 18 bytes (4-byte length + 10-byte
+	// payload + 4-byte CRC). The first write leaves position at 18, under
+	// maxSegmentSize, so no cut happens yet; the second pushes position to
+	// 36, past maxSegmentSize, which is what triggers the cut afterward.
+	records := []string{"0123456789", "abcdefghij"}
+	for _, rec := range records {
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Fatalf("Unable to write %q: %v", rec, err)
+		}
+	}
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for _, want := range records {
+		got, err := r.ReadContext(ctx)
+		if err != nil {
+			t.Fatalf("Unable to read %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
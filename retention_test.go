@@ -0,0 +1,66 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompressBeforeDefersRemovalOfSegmentOpenByReader verifies that
+// CompressBefore, like applyRetention's deletion path, won't unlink a
+// segment a Reader currently has open; removal should wait until the
+// Reader releases it.
+func TestCompressBeforeDefersRemovalOfSegmentOpenByReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("first segment")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	firstSegment := w.filename()
+	if err := w.advance(); err != nil {
+		t.Fatalf("Unable to advance: %v", err)
+	}
+	if _, err := w.Write([]byte("second segment")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+
+	r, err := w.NewReader(newOffset(w.fileSequence, 0))
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+
+	// Point the reader at the first (older) segment instead, as if it were
+	// still catching up, and confirm it's tracked as open.
+	r.fileSequence = filenameToSequence(filepath.Base(firstSegment))
+	r.position = 0
+	if err := r.open(); err != nil {
+		t.Fatalf("Unable to open first segment: %v", err)
+	}
+
+	if err := w.CompressBefore(newOffset(w.fileSequence, 0)); err != nil {
+		t.Fatalf("CompressBefore failed: %v", err)
+	}
+
+	if _, err := os.Stat(firstSegment); err != nil {
+		t.Fatalf("Expected uncompressed segment %v to still exist while a Reader has it open: %v", firstSegment, err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Unable to close reader: %v", err)
+	}
+
+	if _, err := os.Stat(firstSegment); !os.IsNotExist(err) {
+		t.Fatalf("Expected uncompressed segment %v to be removed once the Reader released it, got err=%v", firstSegment, err)
+	}
+}
@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotSuffix names a snapshot's sidecar file, e.g. "1234.snap" for the
+// segment starting at sequence 1234.
+const snapshotSuffix = ".snap"
+
+// snapshotHeaderSize is the fixed-size portion of a .snap file: file
+// sequence, position, meta CRC32, and meta length, each as described below.
+const snapshotHeaderSize = 8 + 8 + 4 + 4
+
+// Snapshot forces a segment cut and records a checkpoint: the Offset at
+// which the new, now-active segment begins, together with an opaque meta
+// blob the caller can use to capture whatever additional state (e.g. a
+// serialized state machine) is needed to resume from here. It's the
+// write-side half of a standard log-compaction/state-machine-replay
+// pattern; pair it with TruncateBeforeSnapshot to discard everything the
+// snapshot has already captured, and NewReaderFromLatestSnapshot to resume
+// from it. The resulting .snap file is a sidecar, not a segment: it's
+// invisible to CompressBefore and TruncateBefore and survives both.
+func (wal *WAL) Snapshot(meta []byte) (Offset, error) {
+	if wal.readOnly {
+		return nil, errReadOnly
+	}
+
+	wal.mx.Lock()
+	defer wal.mx.Unlock()
+
+	if _, err := wal.writer.Write(sentinelBytes); err != nil {
+		return nil, fmt.Errorf("Unable to write sentinel before snapshot: %v", err)
+	}
+	if err := wal.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("Unable to flush before snapshot: %v", err)
+	}
+	if idxErr := writeIndexFile(wal.filename()+indexSuffix, wal.indexEntries); idxErr != nil {
+		log.Errorf("Unable to write index for segment %v: %v", wal.filename(), idxErr)
+	}
+	wal.indexEntries = nil
+	wal.indexRecordsSince = 0
+	wal.indexBytesSince = 0
+	if err := wal.advance(); err != nil {
+		return nil, fmt.Errorf("Unable to advance to new segment for snapshot: %v", err)
+	}
+
+	offset := newOffset(wal.fileSequence, 0)
+	if err := writeSnapshotFile(wal.dir, wal.fileSequence, offset, meta); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.Open(wal.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open directory %v to sync after snapshot: %v", wal.dir, err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return nil, fmt.Errorf("Unable to sync directory %v after snapshot: %v", wal.dir, err)
+	}
+
+	return offset, nil
+}
+
+// LatestSnapshot returns the Offset and meta blob recorded by the most
+// recent call to Snapshot against this WAL's directory, or ErrNoSnapshot if
+// none has been taken.
+func (wal *WAL) LatestSnapshot() (Offset, []byte, error) {
+	files, err := ioutil.ReadDir(wal.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to list log files: %v", err)
+	}
+
+	var latest string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), snapshotSuffix) && f.Name() > latest {
+			latest = f.Name()
+		}
+	}
+	if latest == "" {
+		return nil, nil, ErrNoSnapshot
+	}
+
+	return readSnapshotFile(filepath.Join(wal.dir, latest))
+}
+
+// TruncateBeforeSnapshot deletes every segment strictly older than the
+// latest snapshot recorded by Snapshot.
+func (wal *WAL) TruncateBeforeSnapshot() error {
+	offset, _, err := wal.LatestSnapshot()
+	if err != nil {
+		return err
+	}
+	return wal.TruncateBefore(offset)
+}
+
+// NewReaderFromLatestSnapshot is like NewReader(nil), except that if a
+// snapshot has been taken, it starts from the snapshot's Offset instead of
+// the oldest segment on disk. This lets an application resume state-machine
+// replay from its last checkpoint instead of reprocessing everything written
+// before it.
+func (wal *WAL) NewReaderFromLatestSnapshot() (*Reader, error) {
+	offset, _, err := wal.LatestSnapshot()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return wal.NewReader(nil)
+		}
+		return nil, err
+	}
+	return wal.NewReader(offset)
+}
+
+func writeSnapshotFile(dir string, seq int64, offset Offset, meta []byte) error {
+	path := filepath.Join(dir, sequenceToFilename(seq)+snapshotSuffix)
+
+	buf := make([]byte, snapshotHeaderSize+len(meta))
+	encoding.PutUint64(buf[0:], uint64(offset.FileSequence()))
+	encoding.PutUint64(buf[8:], uint64(offset.Position()))
+	encoding.PutUint32(buf[16:], crc32.ChecksumIEEE(meta))
+	encoding.PutUint32(buf[20:], uint32(len(meta)))
+	copy(buf[snapshotHeaderSize:], meta)
+
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		return fmt.Errorf("Unable to write snapshot file %v: %v", path, err)
+	}
+	return nil
+}
+
+func readSnapshotFile(path string) (Offset, []byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read snapshot file %v: %v", path, err)
+	}
+	if len(raw) < snapshotHeaderSize {
+		return nil, nil, fmt.Errorf("Snapshot file %v is truncated", path)
+	}
+
+	seq := int64(encoding.Uint64(raw[0:]))
+	pos := int64(encoding.Uint64(raw[8:]))
+	wantCRC := encoding.Uint32(raw[16:])
+	metaLen := encoding.Uint32(raw[20:])
+	meta := raw[snapshotHeaderSize:]
+	if uint32(len(meta)) != metaLen {
+		return nil, nil, fmt.Errorf("Snapshot file %v has an inconsistent meta length", path)
+	}
+	if crc32.ChecksumIEEE(meta) != wantCRC {
+		return nil, nil, fmt.Errorf("Snapshot file %v failed its CRC check", path)
+	}
+
+	return newOffset(seq, pos), meta, nil
+}
@@ -2,25 +2,38 @@ package wal
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/getlantern/golog"
-	"github.com/golang/snappy"
 )
 
 const (
 	sentinel          = 0
 	defaultFileBuffer = 65536
 	compressedSuffix  = ".snappy"
+	lengthSize        = 4
+	crcSize           = 4
+	lockFilename      = "LOCK"
+
+	// defaultReadWaitTimeout bounds how long a Reader waits on its
+	// FileWatcher and the WAL's in-process Cond before re-checking for new
+	// data itself, so that a missed or coalesced notification (or a
+	// FileWatcher that fell back to polling) can never stall a Reader for
+	// longer than this.
+	defaultReadWaitTimeout = 1 * time.Second
 )
 
 var (
@@ -29,12 +42,28 @@ var (
 	maxSegmentSize = int64(104857600)
 	encoding       = binary.BigEndian
 	sentinelBytes  = make([]byte, 4) // same as 0
+
+	// errShortSegment indicates that a segment ran out of data mid-frame
+	// because the WAL has already moved on to writing a newer segment,
+	// meaning the frame can never be completed and reading should advance.
+	errShortSegment = errors.New("wal: segment ended mid-frame")
+
+	// ErrLocked is returned by Open/OpenWithOptions when another process
+	// already holds the exclusive lock on the WAL directory.
+	ErrLocked = errors.New("wal: directory is locked by another process")
+
+	errReadOnly = errors.New("wal: cannot write to a WAL opened in read-only mode")
+
+	// ErrNoSnapshot is returned by LatestSnapshot and TruncateBeforeSnapshot
+	// when Snapshot has never been called against this WAL's directory.
+	ErrNoSnapshot = errors.New("wal: no snapshot has been taken")
 )
 
 type filebased struct {
 	dir          string
 	file         *os.File
-	compressed   bool
+	codec        Codec
+	encrypted    bool
 	fileSequence int64
 	position     int64
 	fileFlags    int
@@ -48,12 +77,24 @@ func (fb *filebased) openFile() error {
 			log.Errorf("Unable to close existing file %v: %v", fb.file.Name(), err)
 		}
 	}
-	fb.compressed = false
+	fb.codec = nil
+	fb.encrypted = false
 	fb.file, err = os.OpenFile(fb.filename(), fb.fileFlags, 0600)
 	if os.IsNotExist(err) {
-		// Try compressed version
-		fb.compressed = true
-		fb.file, err = os.OpenFile(fb.filename()+compressedSuffix, fb.fileFlags, 0600)
+		// Try codec-sealed versions, optionally also encrypted
+		for _, codec := range registeredCodecs() {
+			fb.file, err = os.OpenFile(fb.filename()+codec.Suffix(), fb.fileFlags, 0600)
+			if err == nil {
+				fb.codec = codec
+				break
+			}
+			fb.file, err = os.OpenFile(fb.filename()+codec.Suffix()+encryptedSuffix, fb.fileFlags, 0600)
+			if err == nil {
+				fb.codec = codec
+				fb.encrypted = true
+				break
+			}
+		}
 	}
 	return err
 }
@@ -69,27 +110,161 @@ type WAL struct {
 	syncImmediate bool
 	writer        *bufio.Writer
 	mx            sync.RWMutex
+	segmentRefs   *segmentRefCounter
+	lock          *fileLock
+	readOnly      bool
+	compressCodec Codec
+	cipher        *Cipher
+
+	condMx          sync.Mutex
+	cond            *sync.Cond
+	readWaitTimeout time.Duration
+
+	indexEveryRecords int
+	indexEveryBytes   int64
+	indexRecordsSince int
+	indexBytesSince   int64
+	indexEntries      []indexEntry
+}
+
+// RetentionPolicy configures automatic, size- and age-based maintenance of a
+// WAL's segments so that callers don't have to compute offsets themselves
+// and call TruncateBefore/CompressBefore on a schedule. A zero value for any
+// field disables that particular limit.
+type RetentionPolicy struct {
+	// MaxTotalBytes caps the combined size of all segments on disk other
+	// than the active one. When exceeded, the oldest segments are removed
+	// (or, if a Reader still has them open, removal is deferred until that
+	// Reader moves on).
+	MaxTotalBytes int64
+
+	// MaxSegments caps the number of segment files kept on disk, not
+	// counting the active one.
+	MaxSegments int
+
+	// MaxAge removes segments that were started longer ago than this, judged
+	// by their file sequence; a removed segment's newest records may be
+	// younger than MaxAge.
+	MaxAge time.Duration
+
+	// CompressAfter compresses segments older than this that haven't
+	// already been compressed.
+	CompressAfter time.Duration
+
+	// CheckInterval controls how often the policy is evaluated. It
+	// defaults to one minute if unset.
+	CheckInterval time.Duration
 }
 
-// Open opens a WAL in the given directory. It will be force synced to disk
-// every syncInterval. If syncInterval is 0, it will force sync on every write
-// to the WAL.
+// Options configures how a WAL is opened. The zero value opens for writing
+// with no retention enforcement, syncing on every write.
+type Options struct {
+	// SyncInterval is how often the WAL is force synced to disk. If zero, it
+	// force syncs on every write.
+	SyncInterval time.Duration
+
+	// Retention, if non-nil, is enforced by a background goroutine for the
+	// life of the WAL, turning it into a self-maintaining log.
+	Retention *RetentionPolicy
+
+	// ReadOnly opens the WAL without taking the exclusive directory lock and
+	// without appending end-of-segment sentinels to existing files, so that
+	// out-of-band tools (backups, inspectors) can safely read a live WAL
+	// without interfering with the process that's writing to it. A
+	// read-only WAL cannot Write.
+	ReadOnly bool
+
+	// Codec is used by CompressBefore to seal old segments. It defaults to
+	// snappy, matching the WAL's historical behavior. Mixed-codec
+	// directories (e.g. after changing this between restarts) continue to
+	// read correctly, since each sealed segment's filename records the
+	// codec that sealed it.
+	Codec Codec
+
+	// Cipher, if non-nil, is used to encrypt segments as they're sealed by
+	// CompressBefore. The active segment currently being written is never
+	// encrypted, matching the existing "compress old segments only" model.
+	Cipher *Cipher
+
+	// IndexEveryRecords and IndexEveryBytes control how densely a finalized
+	// segment's sidecar index is populated: an entry is recorded at least
+	// every IndexEveryRecords records and at least every IndexEveryBytes of
+	// logical data, whichever comes first. They default to 1000 records and
+	// 1MB respectively; a negative value disables that particular trigger.
+	IndexEveryRecords int
+	IndexEveryBytes   int64
+
+	// ReadWaitTimeout bounds how long a Reader waits between checks for new
+	// data once its FileWatcher and in-process wakeups are exhausted. It
+	// defaults to one second; callers tailing latency-sensitive streams with
+	// many same-process Readers can lower it, though the in-process Cond
+	// already wakes those Readers immediately on Write.
+	ReadWaitTimeout time.Duration
+}
+
+// Open opens a WAL in the given directory, taking an exclusive lock on dir
+// for as long as the WAL stays open. It will be force synced to disk every
+// syncInterval; if syncInterval is 0, it will force sync on every write.
 func Open(dir string, syncInterval time.Duration) (*WAL, error) {
-	err := appendSentinels(dir)
+	return OpenWithOptions(dir, &Options{SyncInterval: syncInterval})
+}
+
+// OpenWithOptions is like Open but accepts the full set of Options,
+// including a RetentionPolicy and read-only mode.
+func OpenWithOptions(dir string, opts *Options) (*WAL, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	wal := &WAL{
+		filebased:         filebased{dir: dir, fileFlags: os.O_CREATE | os.O_APPEND | os.O_WRONLY},
+		segmentRefs:       newSegmentRefCounter(),
+		readOnly:          opts.ReadOnly,
+		compressCodec:     opts.Codec,
+		cipher:            opts.Cipher,
+		readWaitTimeout:   opts.ReadWaitTimeout,
+		indexEveryRecords: opts.IndexEveryRecords,
+		indexEveryBytes:   opts.IndexEveryBytes,
+	}
+	wal.cond = sync.NewCond(&wal.condMx)
+	if wal.indexEveryRecords == 0 {
+		wal.indexEveryRecords = defaultIndexEveryRecords
+	}
+	if wal.indexEveryBytes == 0 {
+		wal.indexEveryBytes = defaultIndexEveryBytes
+	}
+	if wal.readWaitTimeout <= 0 {
+		wal.readWaitTimeout = defaultReadWaitTimeout
+	}
+
+	if opts.ReadOnly {
+		return wal, nil
+	}
+
+	lock, err := lockFile(filepath.Join(dir, lockFilename))
 	if err != nil {
 		return nil, err
 	}
+	wal.lock = lock
 
-	wal := &WAL{filebased: filebased{dir: dir, fileFlags: os.O_CREATE | os.O_APPEND | os.O_WRONLY}}
-	err = wal.advance()
-	if err != nil {
+	if err := appendSentinels(dir); err != nil {
+		wal.lock.unlock()
+		return nil, err
+	}
+
+	if err := wal.advance(); err != nil {
+		wal.lock.unlock()
 		return nil, err
 	}
 
-	if syncInterval <= 0 {
+	if opts.SyncInterval <= 0 {
 		wal.syncImmediate = true
 	} else {
-		go wal.sync(syncInterval)
+		go wal.sync(opts.SyncInterval)
+	}
+
+	if opts.Retention != nil {
+		go wal.enforceRetention(opts.Retention)
 	}
 
 	return wal, nil
@@ -97,14 +272,14 @@ func Open(dir string, syncInterval time.Duration) (*WAL, error) {
 
 func appendSentinels(dir string) error {
 	// Append sentinel values to all existing files just in case
-	files, err := ioutil.ReadDir(dir)
+	files, err := listSegmentFiles(dir)
 	if err != nil {
 		return fmt.Errorf("Unable to list existing log files: %v", err)
 	}
 
 	for _, fileInfo := range files {
-		if strings.HasSuffix(fileInfo.Name(), compressedSuffix) {
-			// Ignore compressed files
+		if isSealed(fileInfo.Name()) {
+			// Ignore already-compressed files
 			continue
 		}
 		file, sentinelErr := os.OpenFile(filepath.Join(dir, fileInfo.Name()), os.O_APPEND|os.O_WRONLY, 0600)
@@ -123,8 +298,65 @@ func appendSentinels(dir string) error {
 	return nil
 }
 
-// Write atomically writes one or more buffers to the WAL.
+// isSegmentFilename reports whether name is a WAL segment file, as opposed
+// to a sidecar (an index, a snapshot) or the directory lock file. A segment
+// is sealed with at most one registered Codec's suffix, optionally also
+// encrypted, so this strips those before checking that what's left is a
+// plain numeric file sequence. Directory scans that rely on "the files are
+// sorted and the last one is the active segment" (TruncateBefore,
+// CompressBefore, applyRetention, appendSentinels, Reader.advance,
+// OffsetForTime) must filter to this before indexing into that sort order,
+// since LOCK sorts after every segment name and an *.idx or *.snap sidecar
+// sorts right after the segment it's named for, including the active one.
+func isSegmentFilename(name string) bool {
+	if name == lockFilename || strings.HasSuffix(name, indexSuffix) || strings.HasSuffix(name, snapshotSuffix) {
+		return false
+	}
+	_, err := strconv.ParseInt(stripSealedSuffix(name), 10, 64)
+	return err == nil
+}
+
+// stripSealedSuffix removes a trailing encryptedSuffix and/or registered
+// Codec suffix from name, leaving the bare file sequence behind. It's the
+// shared logic behind isSegmentFilename and filenameToSequence so that
+// adding a new Codec doesn't require updating both separately.
+func stripSealedSuffix(name string) string {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	for suffix := range codecRegistry {
+		if trimmed := strings.TrimSuffix(name, suffix); trimmed != name {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// listSegmentFiles reads dir and returns only the entries that look like
+// WAL segments, filtering out the lock file and index/snapshot sidecars, in
+// the same name-sorted order ioutil.ReadDir returns. Callers that rely on
+// "the last entry is the active segment" must use this instead of reading
+// the directory directly.
+func listSegmentFiles(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := files[:0]
+	for _, file := range files {
+		if isSegmentFilename(file.Name()) {
+			segments = append(segments, file)
+		}
+	}
+	return segments, nil
+}
+
+// Write atomically writes one or more buffers to the WAL, followed by a
+// CRC32 checksum of the payload so that Reader.Read can detect and recover
+// from a torn write or other corruption of this record.
 func (wal *WAL) Write(bufs ...[]byte) (int, error) {
+	if wal.readOnly {
+		return 0, errReadOnly
+	}
+
 	wal.mx.Lock()
 	defer wal.mx.Unlock()
 
@@ -144,12 +376,41 @@ func (wal *WAL) Write(bufs ...[]byte) (int, error) {
 		return 0, err
 	}
 
+	crc := crc32.NewIEEE()
 	for _, b := range bufs {
 		n, err = wal.writer.Write(b)
 		if err != nil {
 			return 0, err
 		}
 		wal.position += int64(n)
+		crc.Write(b)
+	}
+
+	crcBuf := make([]byte, 4)
+	encoding.PutUint32(crcBuf, crc.Sum32())
+	n, err = wal.writer.Write(crcBuf)
+	wal.position += int64(n)
+	if err != nil {
+		return 0, err
+	}
+
+	wal.indexRecordsSince++
+	wal.indexBytesSince += int64(lengthSize + length + crcSize)
+	recordsDue := wal.indexEveryRecords > 0 && wal.indexRecordsSince >= wal.indexEveryRecords
+	bytesDue := wal.indexEveryBytes > 0 && wal.indexBytesSince >= wal.indexEveryBytes
+	if recordsDue || bytesDue {
+		// The active segment is always plaintext and uncompressed, so the
+		// physical offset is simply the logical position. The entry marks
+		// the start of the record just written, so OffsetForTime lands on a
+		// record written at or before its target time.
+		recordStart := wal.position - int64(lengthSize+length+crcSize)
+		wal.indexEntries = append(wal.indexEntries, indexEntry{
+			logicalPosition: recordStart,
+			physicalOffset:  recordStart,
+			wallTimeNanos:   time.Now().UnixNano(),
+		})
+		wal.indexRecordsSince = 0
+		wal.indexBytesSince = 0
 	}
 
 	if wal.syncImmediate {
@@ -166,18 +427,29 @@ func (wal *WAL) Write(bufs ...[]byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if idxErr := writeIndexFile(wal.filename()+indexSuffix, wal.indexEntries); idxErr != nil {
+			log.Errorf("Unable to write index for segment %v: %v", wal.filename(), idxErr)
+		}
+		wal.indexEntries = nil
+		wal.indexRecordsSince = 0
+		wal.indexBytesSince = 0
 		err = wal.advance()
 		if err != nil {
 			return n, fmt.Errorf("Unable to advance to next file: %v", err)
 		}
 	}
 
+	wal.cond.Broadcast()
 	return n, nil
 }
 
 // TruncateBefore removes all data prior to the given offset from disk.
 func (wal *WAL) TruncateBefore(o Offset) error {
-	files, err := ioutil.ReadDir(wal.dir)
+	if wal.readOnly {
+		return errReadOnly
+	}
+
+	files, err := listSegmentFiles(wal.dir)
 	if err != nil {
 		return fmt.Errorf("Unable to list log files to delete: %v", err)
 	}
@@ -204,9 +476,24 @@ func (wal *WAL) TruncateBeforeTime(ts time.Time) error {
 	return wal.TruncateBefore(newOffset(tsToFileSequence(ts), 0))
 }
 
-// CompressBefore compresses all data prior to the given offset on disk.
+// CompressBefore compresses all data prior to the given offset on disk,
+// using wal's configured Codec (snappy by default) and, if one was
+// configured, encrypting the result with wal's Cipher. It only ever
+// compresses segment files; sidecars like .idx and .snap are never
+// compressed or removed, since listSegmentFiles filters them out before this
+// loop ever sees them. A sealed segment's .idx is rewritten when sealing it
+// records any index entries.
 func (wal *WAL) CompressBefore(o Offset) error {
-	files, err := ioutil.ReadDir(wal.dir)
+	if wal.readOnly {
+		return errReadOnly
+	}
+
+	codec := wal.compressCodec
+	if codec == nil {
+		codec = codecForSuffix(compressedSuffix)
+	}
+
+	files, err := listSegmentFiles(wal.dir)
 	if err != nil {
 		return fmt.Errorf("Unable to list log files to delete: %v", err)
 	}
@@ -218,12 +505,17 @@ func (wal *WAL) CompressBefore(o Offset) error {
 			// encountered the last (active) file, don't bother continuing.
 			break
 		}
-		infile := filepath.Join(wal.dir, file.Name())
-		outfile := infile + compressedSuffix
-		if strings.HasSuffix(file.Name(), compressedSuffix) {
+		if isSealed(file.Name()) {
 			// Already compressed
 			continue
 		}
+
+		infile := filepath.Join(wal.dir, file.Name())
+		outfile := infile + codec.Suffix()
+		if wal.cipher != nil {
+			outfile += encryptedSuffix
+		}
+
 		in, err := os.OpenFile(infile, os.O_RDONLY, 0600)
 		if err != nil {
 			return fmt.Errorf("Unable to open input file %v for compression: %v", infile, err)
@@ -234,8 +526,16 @@ func (wal *WAL) CompressBefore(o Offset) error {
 			return fmt.Errorf("Unable to open outputfile %v to compress %v: %v", outfile, infile, err)
 		}
 		defer out.Close()
-		compressedOut := snappy.NewWriter(out)
-		_, err = io.Copy(compressedOut, bufio.NewReaderSize(in, defaultFileBuffer))
+
+		counted := &countingWriter{w: out}
+		var sealedOut io.Writer = counted
+		var encryptedOut io.WriteCloser
+		if wal.cipher != nil {
+			encryptedOut = wal.cipher.encryptWriter(counted, filenameToSequence(file.Name()))
+			sealedOut = encryptedOut
+		}
+		compressedOut := codec.NewWriter(sealedOut)
+		entries, err := copyWithIndex(compressedOut, bufio.NewReaderSize(in, defaultFileBuffer), counted, wal.indexEveryBytes)
 		if err != nil {
 			return fmt.Errorf("Unable to compress %v: %v", infile, err)
 		}
@@ -243,11 +543,32 @@ func (wal *WAL) CompressBefore(o Offset) error {
 		if err != nil {
 			return fmt.Errorf("Unable to finalize compression of %v: %v", infile, err)
 		}
+		if encryptedOut != nil {
+			if err = encryptedOut.Close(); err != nil {
+				return fmt.Errorf("Unable to finalize encryption of %v: %v", infile, err)
+			}
+		}
 		err = out.Close()
 		if err != nil {
 			return fmt.Errorf("Unable to close compressed output %v: %v", outfile, err)
 		}
-		err = os.Remove(infile)
+
+		// Physical offsets above are only meaningful for seeking when the
+		// segment isn't also encrypted, since Cipher buffers its own chunks
+		// independently of the codec's Flush points; the entries are written
+		// either way. Their wall times are the time of sealing, so
+		// OffsetForTime resolves any time that selects this segment to its
+		// start.
+		idxPath := filepath.Join(wal.dir, sequenceToFilename(filenameToSequence(file.Name()))+indexSuffix)
+		if idxErr := writeIndexFile(idxPath, entries); idxErr != nil {
+			log.Errorf("Unable to write index for compressed segment %v: %v", outfile, idxErr)
+		}
+
+		// A Reader may currently have infile open; deferring its removal
+		// until that Reader releases it avoids yanking a segment out from
+		// under a read in progress, the same protection applyRetention's
+		// deletion path already gets from segmentRefs.
+		err = wal.segmentRefs.removeOrDefer(infile)
 		if err != nil {
 			return fmt.Errorf("Unable to remove uncompressed file %v: %v", infile, err)
 		}
@@ -262,18 +583,127 @@ func (wal *WAL) CompressBeforeTime(ts time.Time) error {
 	return wal.CompressBefore(newOffset(tsToFileSequence(ts), 0))
 }
 
-// Close closes the wal, including flushing any unsaved writes.
+// OffsetForTime returns the Offset of the record nearest to, but not after,
+// ts. It binary-searches segment filenames (which are already time-ordered
+// via tsToFileSequence) to find the segment, then binary-searches that
+// segment's index sidecar to land on the nearest indexed record, turning
+// cold-start replay from a long time range into an O(log n) lookup instead
+// of a linear scan from the start of the WAL. If the target segment has no
+// sidecar yet (for example because it's still the active segment), the
+// returned Offset points at the start of that segment instead. A sealed
+// segment's sidecar is stamped with the time it was sealed, so a time that
+// falls within a sealed segment also resolves to that segment's start.
+func (wal *WAL) OffsetForTime(ts time.Time) (Offset, error) {
+	files, err := listSegmentFiles(wal.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list log files: %v", err)
+	}
+
+	segments := make([]string, 0, len(files))
+	for _, f := range files {
+		segments = append(segments, f.Name())
+	}
+	if len(segments) == 0 {
+		return newOffset(tsToFileSequence(ts), 0), nil
+	}
+
+	cutoff := sequenceToFilename(tsToFileSequence(ts))
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i] > cutoff
+	})
+	if i == 0 {
+		// ts predates every segment on disk.
+		return newOffset(filenameToSequence(segments[0]), 0), nil
+	}
+	segSeq := filenameToSequence(segments[i-1])
+
+	entries, err := readIndexFile(filepath.Join(wal.dir, sequenceToFilename(segSeq)+indexSuffix))
+	if err != nil {
+		log.Debugf("Unable to read index for segment %v, starting from its beginning: %v", sequenceToFilename(segSeq), err)
+	}
+	if len(entries) == 0 {
+		return newOffset(segSeq, 0), nil
+	}
+
+	target := ts.UnixNano()
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j].wallTimeNanos > target
+	})
+	if j == 0 {
+		return newOffset(segSeq, 0), nil
+	}
+	return newOffset(segSeq, entries[j-1].logicalPosition), nil
+}
+
+// RepairSegment truncates the segment file at path to the end of its last
+// complete, checksum-verified frame, discarding any trailing bytes left by
+// a crash mid-write. Run it against a writer's active segment before
+// reopening the WAL so that writing can resume cleanly.
+func RepairSegment(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("Unable to open segment %v for repair: %v", path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, defaultFileBuffer)
+	var validThrough int64
+	var position int64
+	for {
+		lenBuf := make([]byte, lengthSize)
+		n, err := io.ReadFull(reader, lenBuf)
+		position += int64(n)
+		if err != nil {
+			break
+		}
+		length := int(encoding.Uint32(lenBuf))
+		if length <= sentinel {
+			break
+		}
+
+		frame := make([]byte, length+crcSize)
+		n, err = io.ReadFull(reader, frame)
+		position += int64(n)
+		if err != nil {
+			break
+		}
+		if encoding.Uint32(frame[length:]) != crc32.ChecksumIEEE(frame[:length]) {
+			break
+		}
+
+		validThrough = position
+	}
+
+	if truncErr := file.Truncate(validThrough); truncErr != nil {
+		return fmt.Errorf("Unable to truncate segment %v to last valid frame at %d: %v", path, validThrough, truncErr)
+	}
+	return nil
+}
+
+// Close closes the wal, including flushing any unsaved writes and releasing
+// its exclusive lock on the directory, if any.
 func (wal *WAL) Close() error {
+	if wal.readOnly {
+		return nil
+	}
+
 	flushErr := wal.writer.Flush()
 	syncErr := wal.file.Sync()
 	closeErr := wal.file.Close()
+	var unlockErr error
+	if wal.lock != nil {
+		unlockErr = wal.lock.unlock()
+	}
 	if flushErr != nil {
 		return flushErr
 	}
 	if syncErr != nil {
 		return syncErr
 	}
-	return closeErr
+	if closeErr != nil {
+		return closeErr
+	}
+	return unlockErr
 }
 
 func (wal *WAL) advance() error {
@@ -282,6 +712,7 @@ func (wal *WAL) advance() error {
 	err := wal.openFile()
 	if err == nil {
 		wal.writer = bufio.NewWriterSize(wal.file, defaultFileBuffer)
+		wal.cond.Broadcast()
 	}
 	return err
 }
@@ -311,15 +742,176 @@ func (wal *WAL) hasMovedBeyond(fileSequence int64) bool {
 	wal.mx.RLock()
 	hasMovedBeyond := wal.fileSequence > fileSequence
 	wal.mx.RUnlock()
-	return hasMovedBeyond
+	if hasMovedBeyond {
+		return true
+	}
+	if !wal.readOnly {
+		return false
+	}
+	// A read-only WAL never writes, so it has no active segment of its own
+	// to compare against; fall back to checking whether some other process
+	// has written a newer segment to disk.
+	files, err := ioutil.ReadDir(wal.dir)
+	if err != nil {
+		return false
+	}
+	for _, fileInfo := range files {
+		if filenameToSequence(fileInfo.Name()) > fileSequence {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentRefCounter tracks how many Readers currently have each segment
+// file open, keyed by full path, so that the retention manager can delete
+// segments that are no longer wanted without deleting one out from under a
+// Reader that's still reading it. Deletion of a referenced segment is
+// deferred until its last Reader releases it.
+type segmentRefCounter struct {
+	mx      sync.Mutex
+	refs    map[string]int
+	pending map[string]bool
+}
+
+func newSegmentRefCounter() *segmentRefCounter {
+	return &segmentRefCounter{refs: make(map[string]int), pending: make(map[string]bool)}
+}
+
+func (c *segmentRefCounter) acquire(path string) {
+	c.mx.Lock()
+	c.refs[path]++
+	c.mx.Unlock()
+}
+
+func (c *segmentRefCounter) release(path string) {
+	c.mx.Lock()
+	c.refs[path]--
+	shouldDelete := false
+	if c.refs[path] <= 0 {
+		delete(c.refs, path)
+		if c.pending[path] {
+			delete(c.pending, path)
+			shouldDelete = true
+		}
+	}
+	c.mx.Unlock()
+
+	if shouldDelete {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Unable to remove deferred segment %v: %v", path, err)
+		}
+	}
+}
+
+// removeOrDefer deletes the segment at path unless a Reader currently has it
+// open, in which case deletion is deferred until that Reader releases it.
+func (c *segmentRefCounter) removeOrDefer(path string) error {
+	c.mx.Lock()
+	if c.refs[path] > 0 {
+		c.pending[path] = true
+		c.mx.Unlock()
+		return nil
+	}
+	c.mx.Unlock()
+	return os.Remove(path)
+}
+
+// enforceRetention periodically applies policy for the lifetime of the WAL.
+func (wal *WAL) enforceRetention(policy *RetentionPolicy) {
+	interval := policy.CheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for {
+		time.Sleep(interval)
+		if err := wal.applyRetention(policy); err != nil {
+			log.Errorf("Unable to apply retention policy: %v", err)
+		}
+	}
+}
+
+// applyRetention runs one pass of compression and deletion per policy. It
+// never touches the active (currently-being-written) segment.
+func (wal *WAL) applyRetention(policy *RetentionPolicy) error {
+	if policy.CompressAfter > 0 {
+		cutoff := tsToFileSequence(time.Now().Add(-policy.CompressAfter))
+		if err := wal.CompressBefore(newOffset(cutoff, 0)); err != nil {
+			log.Errorf("Unable to compress aged WAL segments: %v", err)
+		}
+	}
+
+	if policy.MaxAge > 0 {
+		cutoff := tsToFileSequence(time.Now().Add(-policy.MaxAge))
+		if err := wal.TruncateBefore(newOffset(cutoff, 0)); err != nil {
+			log.Errorf("Unable to remove aged WAL segments: %v", err)
+		}
+	}
+
+	files, err := listSegmentFiles(wal.dir)
+	if err != nil {
+		return fmt.Errorf("Unable to list log files: %v", err)
+	}
+	// The last file is always the active segment currently being written;
+	// never consider it for removal.
+	if len(files) > 0 {
+		files = files[:len(files)-1]
+	}
+
+	if policy.MaxSegments > 0 && len(files) > policy.MaxSegments {
+		for _, file := range files[:len(files)-policy.MaxSegments] {
+			path := filepath.Join(wal.dir, file.Name())
+			if err := wal.segmentRefs.removeOrDefer(path); err != nil {
+				log.Errorf("Unable to remove excess WAL segment %v: %v", path, err)
+			} else {
+				log.Debugf("Removed excess WAL segment %v", path)
+			}
+		}
+		// Only the segments kept above count toward MaxTotalBytes below.
+		files = files[len(files)-policy.MaxSegments:]
+	}
+
+	if policy.MaxTotalBytes > 0 {
+		var total int64
+		for _, file := range files {
+			total += file.Size()
+		}
+		for _, file := range files {
+			if total <= policy.MaxTotalBytes {
+				break
+			}
+			path := filepath.Join(wal.dir, file.Name())
+			if err := wal.segmentRefs.removeOrDefer(path); err != nil {
+				log.Errorf("Unable to remove WAL segment %v over total size budget: %v", path, err)
+				continue
+			}
+			total -= file.Size()
+			log.Debugf("Removed WAL segment %v to stay under MaxTotalBytes", path)
+		}
+	}
+
+	return nil
 }
 
 // Reader allows reading from a WAL. It is NOT safe to read from a single Reader
 // from multiple goroutines.
 type Reader struct {
 	filebased
-	wal    *WAL
-	reader io.Reader
+	wal         *WAL
+	reader      io.Reader
+	openSegment string
+
+	// knownFileSize caches the last Stat()'d size of the currently open
+	// segment, so remainingBytesOnDisk only needs to stat the file again
+	// once position catches up with what it already knows about, rather
+	// than on every record.
+	knownFileSize int64
+
+	watcher     FileWatcher
+	watchedFile string
+	notify      chan struct{}
+	watcherDone chan struct{}
+	closed      bool // guarded by wal.condMx, not r itself
 }
 
 // NewReader constructs a new Reader for reading from this WAL starting at the
@@ -327,6 +919,7 @@ type Reader struct {
 // goroutines.
 func (wal *WAL) NewReader(offset Offset) (*Reader, error) {
 	r := &Reader{filebased: filebased{dir: wal.dir, fileFlags: os.O_RDONLY}, wal: wal}
+	r.startWatching()
 	if offset != nil {
 		offsetString := sequenceToFilename(offset.FileSequence())
 		if offsetString[0] != '0' {
@@ -334,8 +927,9 @@ func (wal *WAL) NewReader(offset Offset) (*Reader, error) {
 			offset = newOffset(offset.FileSequence()/1000, offset.Position())
 		}
 
-		files, err := ioutil.ReadDir(wal.dir)
+		files, err := listSegmentFiles(wal.dir)
 		if err != nil {
+			r.Close()
 			return nil, fmt.Errorf("Unable to list existing log files: %v", err)
 		}
 
@@ -353,6 +947,7 @@ func (wal *WAL) NewReader(offset Offset) (*Reader, error) {
 				}
 				openErr := r.open()
 				if openErr != nil {
+					r.Close()
 					return nil, fmt.Errorf("Unable to open existing log file at %v: %v", fileInfo.Name(), openErr)
 				}
 				break
@@ -362,8 +957,9 @@ func (wal *WAL) NewReader(offset Offset) (*Reader, error) {
 
 	if r.file == nil {
 		// Didn't find WAL file, advance
-		err := r.advance()
+		err := r.advance(context.Background())
 		if err != nil {
+			r.Close()
 			return nil, fmt.Errorf("Unable to advance initially: %v", err)
 		}
 		log.Debugf("Replaying log starting at %v", r.file.Name())
@@ -372,76 +968,272 @@ func (wal *WAL) NewReader(offset Offset) (*Reader, error) {
 }
 
 // Read reads the next chunk from the WAL, blocking until one is available.
+// If a record's length or CRC32 checksum looks corrupt (for example due to
+// a torn write), Read logs the problem and recovers by scanning forward for
+// the next valid frame rather than returning bad data or failing outright.
+// It never returns an error due to waiting; use ReadContext to bound the
+// wait with a context.Context instead.
 func (r *Reader) Read() ([]byte, error) {
+	return r.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but returns ctx.Err() if ctx is done before a
+// chunk becomes available.
+func (r *Reader) ReadContext(ctx context.Context) ([]byte, error) {
 top:
 	for {
-		// Read length
-		lenBuf := make([]byte, 4)
+		length, recovered, err := r.readLength(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if recovered != nil {
+			return recovered, nil
+		}
+
+		b, crcOK, err := r.readFrame(ctx, length)
+		if err == errShortSegment {
+			if advErr := r.advance(ctx); advErr != nil {
+				return nil, advErr
+			}
+			continue top
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !crcOK {
+			log.Errorf("CRC mismatch reading frame from %v at position %d, scanning forward to recover", r.filename(), r.position)
+			recovered, recErr := r.recover(ctx)
+			if recErr != nil {
+				return nil, recErr
+			}
+			return recovered, nil
+		}
+
+		return b, nil
+	}
+}
+
+// remainingBytesOnDisk returns how many more bytes are available to read
+// from the current segment's underlying file, used to sanity-check a length
+// prefix before trusting it. It only applies to plain (uncompressed,
+// unencrypted) segments, where r.position is a true byte offset into the
+// file: Write only checks position >= maxSegmentSize after appending a
+// record, so a segment's logical size routinely exceeds maxSegmentSize by
+// up to one record, making maxSegmentSize itself an unreliable bound (see
+// etcd's wal package, which stats the file for the same reason). Compressed
+// or encrypted segments are always sealed before a Reader gets to them, so a
+// bogus length there is already caught safely by readFrame's own
+// short-segment detection instead; the second return value is false in
+// that case to signal "no bound to check".
+func (r *Reader) remainingBytesOnDisk() (int64, bool, error) {
+	if r.codec != nil || r.encrypted {
+		return 0, false, nil
+	}
+	if r.position >= r.knownFileSize {
+		info, err := r.file.Stat()
+		if err != nil {
+			return 0, false, fmt.Errorf("Unable to stat %v: %v", r.filename(), err)
+		}
+		r.knownFileSize = info.Size()
+	}
+	return r.knownFileSize - r.position, true, nil
+}
+
+// readLength reads the next record's length prefix, skipping sentinel
+// values (which mark the end of a segment) by advancing to the next one.
+// In a plain segment the returned length is bounded by the bytes remaining
+// in the file (see remainingBytesOnDisk); a length that doesn't fit is
+// treated like any other corruption, and readLength recovers from it
+// directly, returning the recovered frame in place of a length for the
+// caller to use as-is. A sealed segment's length isn't bounded here, but
+// readFrame reads the payload in chunks, so a corrupt length field can never
+// trigger an outsized allocation in either case.
+func (r *Reader) readLength(ctx context.Context) (int, []byte, error) {
+	for {
+		lenBuf := make([]byte, lengthSize)
 		read := 0
-		length := 0
-		for {
-			read = 0
-
-			for {
-				n, err := r.reader.Read(lenBuf[read:])
-				if err != nil && err.Error() == "EOF" && n == 0 {
-					time.Sleep(50 * time.Millisecond)
-					continue
-				}
-				if err != nil {
-					log.Errorf("Unexpected error reading length from WAL file %v: %v", r.filename(), err)
-					break
-				}
-				read += n
-				r.position += int64(n)
-				if read == 4 {
-					length = int(encoding.Uint32(lenBuf))
-					break
+		for read < lengthSize {
+			n, err := r.reader.Read(lenBuf[read:])
+			// Some codec readers (e.g. compress/gzip's) return the final
+			// chunk of data together with io.EOF in the same call; take the
+			// data before deciding what to do about the error.
+			read += n
+			r.position += int64(n)
+			if read == lengthSize {
+				break
+			}
+			if err != nil && err.Error() == "EOF" {
+				if waitErr := r.waitForMore(ctx); waitErr != nil {
+					return 0, nil, waitErr
 				}
+				continue
 			}
-
-			if length > sentinel {
+			if err != nil {
+				log.Errorf("Unexpected error reading length from WAL file %v: %v", r.filename(), err)
 				break
 			}
+		}
 
-			err := r.advance()
+		if read < lengthSize {
+			recovered, err := r.recover(ctx)
 			if err != nil {
-				return nil, err
+				return 0, nil, err
 			}
+			return 0, recovered, nil
 		}
 
-		// Read data
-		b := make([]byte, length)
-		read = 0
-		for {
-			n, err := r.reader.Read(b[read:])
-			if err != nil && err.Error() == "EOF" && n == 0 {
-				if r.wal.hasMovedBeyond(r.fileSequence) {
-					log.Errorf("Out of data to read after reading %d, and WAL has moved beyond %d. Assuming WAL at %v corrupted. Advancing and continuing.", r.position, r.fileSequence, r.filename())
-					err := r.advance()
-					if err != nil {
-						return nil, err
-					}
-					continue top
-				}
-				// No newer log files, continue trying to read from this one
-				time.Sleep(50 * time.Millisecond)
-				continue
+		length := int(encoding.Uint32(lenBuf))
+		if length <= sentinel {
+			if err := r.advance(ctx); err != nil {
+				return 0, nil, err
 			}
+			continue
+		}
 
+		remaining, boundOK, remErr := r.remainingBytesOnDisk()
+		if remErr != nil {
+			return 0, nil, remErr
+		}
+		if boundOK && int64(length) > remaining {
+			log.Errorf("Length %d read from %v exceeds %d bytes remaining in segment, treating as corruption", length, r.filename(), remaining)
+			recovered, err := r.recover(ctx)
 			if err != nil {
-				log.Errorf("Unexpected error reading data from WAL file %v: %v", r.filename(), err)
-				continue top
+				return 0, nil, err
 			}
+			return 0, recovered, nil
+		}
 
-			read += n
-			r.position += int64(n)
-			if read == length {
-				break
+		return length, nil, nil
+	}
+}
+
+// readFrame reads a record's payload and trailing CRC32, reporting whether
+// the checksum verified. It returns errShortSegment if the segment ends
+// before a full frame could be read and the WAL has since moved on to a
+// newer segment, meaning this frame will never be completed.
+func (r *Reader) readFrame(ctx context.Context, length int) ([]byte, bool, error) {
+	// Grow b only as data actually arrives, at most defaultFileBuffer bytes
+	// at a time, so a corrupt length can't force one huge allocation.
+	var b []byte
+	for len(b) < length {
+		chunk := length - len(b)
+		if chunk > defaultFileBuffer {
+			chunk = defaultFileBuffer
+		}
+		start := len(b)
+		b = append(b, make([]byte, chunk)...)
+		if err := r.readFull(ctx, b[start:]); err != nil {
+			return nil, false, err
+		}
+	}
+
+	crcBuf := make([]byte, crcSize)
+	if err := r.readFull(ctx, crcBuf); err != nil {
+		return nil, false, err
+	}
+
+	return b, encoding.Uint32(crcBuf) == crc32.ChecksumIEEE(b), nil
+}
+
+// readFull reads exactly len(b) bytes, waiting for more data to be appended
+// to the active segment. If this segment is exhausted and the WAL has moved
+// on to a newer one, it returns errShortSegment instead of blocking forever.
+func (r *Reader) readFull(ctx context.Context, b []byte) error {
+	read := 0
+	for read < len(b) {
+		n, err := r.reader.Read(b[read:])
+		// Some codec readers (e.g. compress/gzip's) return the final chunk
+		// of data together with io.EOF in the same call; take the data
+		// before deciding what to do about the error, or it's silently
+		// dropped and this frame comes up short.
+		read += n
+		r.position += int64(n)
+		if read == len(b) {
+			break
+		}
+		if err != nil && err.Error() == "EOF" {
+			if r.wal.hasMovedBeyond(r.fileSequence) {
+				log.Errorf("Out of data to read after reading %d, and WAL has moved beyond %d. Assuming WAL at %v corrupted.", r.position, r.fileSequence, r.filename())
+				return errShortSegment
+			}
+			// No newer log files, continue trying to read from this one
+			if waitErr := r.waitForMore(ctx); waitErr != nil {
+				return waitErr
+			}
+			continue
+		}
+		if err != nil {
+			log.Errorf("Unexpected error reading data from WAL file %v: %v", r.filename(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// recover resynchronizes after a corrupt frame by scanning forward one byte
+// at a time for a length prefix that, combined with the bytes that follow
+// it, forms a frame whose CRC32 validates. If the current segment is
+// exhausted without finding one and the WAL has moved on, it advances to
+// the next segment and resumes scanning there.
+func (r *Reader) recover(ctx context.Context) ([]byte, error) {
+	var window []byte
+	for {
+		buf := make([]byte, 1)
+		n, err := r.reader.Read(buf)
+		// Some codec readers (e.g. compress/gzip's) return their final byte
+		// together with io.EOF in the same call; take it before deciding
+		// what to do about the error.
+		r.position += int64(n)
+		window = append(window, buf[:n]...)
+		if n == 0 && err != nil && err.Error() == "EOF" {
+			if r.wal.hasMovedBeyond(r.fileSequence) {
+				log.Errorf("Exhausted %v while recovering without finding a valid frame, advancing to next segment", r.filename())
+				if advErr := r.advance(ctx); advErr != nil {
+					return nil, advErr
+				}
+				window = nil
+				continue
+			}
+			if waitErr := r.waitForMore(ctx); waitErr != nil {
+				return nil, waitErr
 			}
+			continue
+		}
+		if n == 0 && err != nil {
+			return nil, err
+		}
+		if len(window) > lengthSize {
+			window = window[len(window)-lengthSize:]
+		}
+		if len(window) < lengthSize {
+			continue
 		}
 
-		return b, nil
+		length := int(encoding.Uint32(window))
+		if length <= sentinel {
+			continue
+		}
+		remaining, boundOK, remErr := r.remainingBytesOnDisk()
+		if remErr != nil {
+			return nil, remErr
+		}
+		if boundOK && int64(length) > remaining {
+			continue
+		}
+
+		b, crcOK, err := r.readFrame(ctx, length)
+		if err == errShortSegment {
+			window = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if crcOK {
+			log.Debugf("Recovered valid frame in %v at position %d", r.filename(), r.position)
+			return b, nil
+		}
+		window = nil
 	}
 }
 
@@ -453,23 +1245,86 @@ func (r *Reader) Offset() Offset {
 
 // Close closes the Reader.
 func (r *Reader) Close() error {
+	if r.watcherDone != nil {
+		// Setting closed under condMx, the same lock cond.Wait releases and
+		// reacquires, guarantees pumpCondBroadcasts observes it either
+		// before its next Wait (via the loop condition) or is woken by this
+		// Broadcast while already waiting — so the wakeup can never be lost
+		// to the gap between a Wait returning and the next one starting.
+		r.wal.condMx.Lock()
+		r.closed = true
+		r.wal.condMx.Unlock()
+		r.wal.cond.Broadcast()
+		close(r.watcherDone)
+	}
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+	r.releaseSegmentRef()
+	r.closeCodecReader()
 	return r.file.Close()
 }
 
+// closeCodecReader closes r.reader if the Codec wrapping it supports
+// closing, as gzipCodec's gzip.Reader (Close() error) and zstdCodec's
+// *zstd.Decoder (Close(), no error) both do: zstd.NewReader in particular
+// spawns a background decode goroutine that leaks if abandoned mid-stream
+// without being closed. snappyCodec's *snappy.Reader and errorReader don't
+// implement either, so this is a no-op for them. The two shapes are
+// checked separately since Close() error and Close() are distinct method
+// signatures that don't satisfy a single interface.
+func (r *Reader) closeCodecReader() {
+	switch closer := r.reader.(type) {
+	case io.Closer:
+		if err := closer.Close(); err != nil {
+			log.Errorf("Unable to close codec reader for %v: %v", r.filename(), err)
+		}
+	case interface{ Close() }:
+		closer.Close()
+	}
+}
+
 func (r *Reader) open() error {
+	r.closeCodecReader()
+	r.knownFileSize = 0
 	err := r.openFile()
 	if err != nil {
 		return err
 	}
+	r.trackSegmentRef()
+	r.watchSegment()
+
+	// If the segment has an index sidecar, jump the underlying file close
+	// to r.position before wrapping it in the codec/cipher readers, turning
+	// an O(position) decode into a short linear scan from the nearest
+	// indexed checkpoint. This only applies to unencrypted segments, since
+	// a Cipher's chunk boundaries don't line up with the codec's Flush
+	// points that the index physical offsets were recorded at.
+	logicalStart := int64(0)
+	if r.position > 0 && !r.encrypted {
+		if entry, ok := r.nearestIndexEntry(); ok {
+			if _, seekErr := r.file.Seek(entry.physicalOffset, io.SeekStart); seekErr != nil {
+				return fmt.Errorf("Unable to seek to indexed offset %d in %v: %v", entry.physicalOffset, r.filename(), seekErr)
+			}
+			logicalStart = entry.logicalPosition
+		}
+	}
+
 	r.reader = bufio.NewReaderSize(r.file, defaultFileBuffer)
-	if r.compressed {
-		r.reader = snappy.NewReader(r.reader)
-	}
-	if r.position > 0 {
-		// Read to the correct offset
-		// Note - we cannot just seek on the file because the data is compressed and
-		// the recorded position does not correspond to a file offset.
-		_, seekErr := io.CopyN(ioutil.Discard, r.reader, r.position)
+	if r.encrypted {
+		if r.wal.cipher == nil {
+			return fmt.Errorf("Segment %v is encrypted but this WAL has no Cipher configured", r.filename())
+		}
+		r.reader = r.wal.cipher.decryptReader(r.reader, r.fileSequence)
+	}
+	if r.codec != nil {
+		r.reader = r.codec.NewReader(r.reader)
+	}
+	if remaining := r.position - logicalStart; remaining > 0 {
+		// Read to the correct offset. We cannot just seek on the file
+		// beyond this point because the data may be compressed and the
+		// recorded position doesn't otherwise correspond to a file offset.
+		_, seekErr := io.CopyN(ioutil.Discard, r.reader, remaining)
 		if seekErr != nil {
 			return seekErr
 		}
@@ -477,9 +1332,134 @@ func (r *Reader) open() error {
 	return nil
 }
 
-func (r *Reader) advance() error {
+// trackSegmentRef registers this Reader's currently open segment with the
+// WAL's ref counter (releasing whichever segment it held previously), so
+// that retention enforcement won't delete a segment out from under it.
+func (r *Reader) trackSegmentRef() {
+	if r.wal.segmentRefs == nil {
+		return
+	}
+	r.releaseSegmentRef()
+	r.openSegment = r.file.Name()
+	r.wal.segmentRefs.acquire(r.openSegment)
+}
+
+func (r *Reader) releaseSegmentRef() {
+	if r.wal.segmentRefs == nil || r.openSegment == "" {
+		return
+	}
+	r.wal.segmentRefs.release(r.openSegment)
+	r.openSegment = ""
+}
+
+// startWatching arranges for this Reader to be woken by filesystem changes
+// and by same-process Writes instead of polling on a fixed interval. It's
+// called once, from NewReader; Close stops it.
+func (r *Reader) startWatching() {
+	r.notify = make(chan struct{}, 1)
+	r.watcherDone = make(chan struct{})
+	r.watcher = newFileWatcher()
+	if err := r.watcher.Add(r.dir); err != nil {
+		log.Debugf("Unable to watch %v for new segments, falling back to the wait timeout: %v", r.dir, err)
+	}
+
+	go r.pumpWatcherEvents()
+	go r.pumpCondBroadcasts()
+}
+
+// watchSegment switches the FileWatcher from watching the previously open
+// segment (if any) to the one just opened, so writes to the active segment
+// wake this Reader without it needing to poll.
+func (r *Reader) watchSegment() {
+	if r.watchedFile != "" {
+		r.watcher.Remove(r.watchedFile)
+		r.watchedFile = ""
+	}
+	if err := r.watcher.Add(r.filename()); err != nil {
+		log.Debugf("Unable to watch %v for writes, falling back to the wait timeout: %v", r.filename(), err)
+		return
+	}
+	r.watchedFile = r.filename()
+}
+
+func (r *Reader) pumpWatcherEvents() {
 	for {
-		files, err := ioutil.ReadDir(r.dir)
+		select {
+		case <-r.watcher.Events():
+			r.signal()
+		case <-r.watcher.Errors():
+			r.signal()
+		case <-r.watcherDone:
+			return
+		}
+	}
+}
+
+// pumpCondBroadcasts forwards wakeups from the WAL's in-process Cond, which
+// Write and advance broadcast to on every write and segment cut, letting a
+// same-process Reader notice new data immediately without waiting on a
+// filesystem notification round-trip. r.closed is checked under the same
+// lock cond.Wait uses, so Close setting it can never race past an already
+// up-to-date check straight into a Wait with no one left to wake it.
+func (r *Reader) pumpCondBroadcasts() {
+	r.wal.condMx.Lock()
+	defer r.wal.condMx.Unlock()
+	for !r.closed {
+		r.wal.cond.Wait()
+		if !r.closed {
+			r.signal()
+		}
+	}
+}
+
+func (r *Reader) signal() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// waitForMore blocks until this Reader's FileWatcher or the WAL's
+// in-process Cond signals a possible change, ctx is done, or
+// wal.readWaitTimeout elapses, whichever comes first. It returns an error
+// only when ctx is the reason it returned, so callers can tell "keep
+// trying" apart from "give up".
+func (r *Reader) waitForMore(ctx context.Context) error {
+	timer := time.NewTimer(r.wal.readWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-r.notify:
+		return nil
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nearestIndexEntry returns the last indexed entry at or before r.position
+// in this segment's sidecar, if one exists.
+func (r *Reader) nearestIndexEntry() (indexEntry, bool) {
+	entries, err := readIndexFile(filepath.Join(r.dir, sequenceToFilename(r.fileSequence)+indexSuffix))
+	if err != nil {
+		log.Debugf("Unable to read index for %v, falling back to a full scan: %v", r.filename(), err)
+	}
+	if len(entries) == 0 {
+		return indexEntry{}, false
+	}
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].logicalPosition > r.position
+	})
+	if i == 0 {
+		return indexEntry{}, false
+	}
+	return entries[i-1], true
+}
+
+func (r *Reader) advance(ctx context.Context) error {
+	for {
+		files, err := listSegmentFiles(r.dir)
 		if err != nil {
 			return fmt.Errorf("Unable to list existing log files: %v", err)
 		}
@@ -500,7 +1480,9 @@ func (r *Reader) advance() error {
 			}
 		}
 
-		time.Sleep(50 * time.Millisecond)
+		if err := r.waitForMore(ctx); err != nil {
+			return err
+		}
 	}
 }
 
@@ -518,7 +1500,7 @@ func sequenceToFilename(seq int64) string {
 
 func filenameToSequence(filename string) int64 {
 	_, filePart := filepath.Split(filename)
-	filePart = strings.TrimSuffix(filePart, compressedSuffix)
+	filePart = stripSealedSuffix(filePart)
 	seq, err := strconv.ParseInt(filePart, 10, 64)
 	if err != nil {
 		log.Errorf("Unparseable filename '%v': %v", filename, err)
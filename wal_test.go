@@ -0,0 +1,232 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTruncateBeforeNeverDeletesActiveSegment guards against the LOCK file
+// (which always sorts after every numeric segment name) being mistaken for
+// the active segment by TruncateBefore's "last sorted file is active" check.
+// A cutoff at or beyond the active segment's sequence must never delete it.
+func TestTruncateBeforeNeverDeletesActiveSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("still needed")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	activeSegment := w.filename()
+
+	// A cutoff past the active segment's own sequence is exactly what
+	// RetentionPolicy.MaxAge drives on every check interval once the WAL
+	// has been sitting idle for a moment.
+	if err := w.TruncateBefore(newOffset(w.fileSequence+1, 0)); err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+
+	if _, err := os.Stat(activeSegment); err != nil {
+		t.Fatalf("Active segment %v must survive TruncateBefore: %v", activeSegment, err)
+	}
+}
+
+// TestWriteReadAcrossSegments is a basic round trip covering segment
+// rotation: writing enough records to force several cuts, then reading them
+// all back in order from the beginning.
+func TestWriteReadAcrossSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldMaxSegmentSize := maxSegmentSize
+	maxSegmentSize = 11 // forces a new segment after each small record below
+	defer func() { maxSegmentSize = oldMaxSegmentSize }()
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	records := []string{"alpha", "beta", "gamma", "delta"}
+	for _, rec := range records {
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Fatalf("Unable to write %q: %v", rec, err)
+		}
+	}
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	for _, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unable to read %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+// TestOpenCloseOpenResumesFromLastOffset confirms that a WAL reopened after
+// a clean Close continues past everything written before it closed, which
+// is the building block every snapshot/retention/compress feature assumes
+// survives a restart.
+func TestOpenCloseOpenResumesFromLastOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	if _, err := w.Write([]byte("before restart")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unable to close: %v", err)
+	}
+
+	w, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to reopen: %v", err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("after restart")); err != nil {
+		t.Fatalf("Unable to write after reopen: %v", err)
+	}
+
+	r, err := w.NewReader(nil)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %v", err)
+	}
+	defer r.Close()
+
+	for _, want := range []string{"before restart", "after restart"} {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unable to read %q: %v", want, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+// TestOpenWithOptionsReadOnlyDoesNotLock confirms a read-only open can
+// coexist with a writer already holding the directory lock, so out-of-band
+// tools can inspect a live WAL.
+func TestOpenWithOptionsReadOnlyDoesNotLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	ro, err := OpenWithOptions(dir, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Read-only open should not be blocked by the writer's lock: %v", err)
+	}
+	defer ro.Close()
+
+	second, err := OpenWithOptions(dir, &Options{})
+	if err == nil {
+		second.Close()
+		t.Fatalf("Expected a second writable Open to fail with ErrLocked")
+	}
+	if err != ErrLocked {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+}
+
+// TestReadOnlyRejectsTruncateAndCompress confirms a read-only handle can't
+// delete or rewrite segments out from under the real writer, which would
+// otherwise be possible since ReadOnly skips taking the directory lock.
+func TestReadOnlyRejectsTruncateAndCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+
+	ro, err := OpenWithOptions(dir, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Unable to open read-only: %v", err)
+	}
+	defer ro.Close()
+
+	if err := ro.TruncateBefore(newOffset(ro.fileSequence+1, 0)); err != errReadOnly {
+		t.Fatalf("Expected errReadOnly from TruncateBefore, got %v", err)
+	}
+	if err := ro.CompressBefore(newOffset(ro.fileSequence+1, 0)); err != errReadOnly {
+		t.Fatalf("Expected errReadOnly from CompressBefore, got %v", err)
+	}
+}
+
+// TestRetentionPolicyNeverRemovesActiveSegment exercises applyRetention
+// directly (rather than waiting on its background goroutine) to confirm
+// MaxAge/MaxSegments never touch the segment currently being written, even
+// once the LOCK file is present in the directory listing.
+func TestRetentionPolicyNeverRemovesActiveSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waltest")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Unable to open WAL: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("keep me")); err != nil {
+		t.Fatalf("Unable to write: %v", err)
+	}
+	activeSegment := w.filename()
+
+	policy := &RetentionPolicy{MaxAge: time.Nanosecond, MaxSegments: 1}
+	if err := w.applyRetention(policy); err != nil {
+		t.Fatalf("applyRetention failed: %v", err)
+	}
+
+	if _, err := os.Stat(activeSegment); err != nil {
+		t.Fatalf("Active segment %v must survive retention enforcement: %v", activeSegment, err)
+	}
+}